@@ -0,0 +1,349 @@
+// conntrack.go - conntrack-based flow enrichment for DNAT'd addresses
+package main
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// conntrackMaxEntries caps the in-memory flow cache so a busy node doesn't
+// grow it unbounded; the least recently used entry is evicted first.
+const conntrackMaxEntries = 65536
+
+// conntrackKey is the 5-tuple a flow is looked up by.
+type conntrackKey struct {
+	proto   uint8
+	srcIP   string
+	srcPort uint16
+	dstIP   string
+	dstPort uint16
+}
+
+// origDst is the address a client actually dialed, before DNAT.
+type origDst struct {
+	ip   string
+	port uint16
+}
+
+type lruEntry struct {
+	key conntrackKey
+	dst origDst
+}
+
+// conntrackEnricher maintains an LRU of conntrack flows keyed by the reply
+// (post-DNAT) tuple, so an AudioEvent captured on the wire can be mapped
+// back to the ClusterIP/Service a client actually dialed. It's seeded at
+// startup from the current conntrack table and kept warm by listening on
+// the NFNLGRP_CONNTRACK_NEW/DESTROY multicast groups: the vishvananda/netlink
+// library only wraps the dump/query side of nfnetlink conntrack
+// (ConntrackTableList), not event subscription, so the event socket here is
+// hand-rolled against the raw nfnetlink wire format.
+type conntrackEnricher struct {
+	mu    sync.Mutex
+	cache map[conntrackKey]*list.Element
+	order *list.List // front = most recently used
+}
+
+func newConntrackEnricher() *conntrackEnricher {
+	return &conntrackEnricher{
+		cache: make(map[conntrackKey]*list.Element),
+		order: list.New(),
+	}
+}
+
+// seed populates the cache from the current conntrack table.
+func (c *conntrackEnricher) seed() error {
+	flows, err := netlink.ConntrackTableList(netlink.ConntrackTable, unix.AF_INET)
+	if err != nil {
+		return fmt.Errorf("failed to list conntrack table: %w", err)
+	}
+	for _, flow := range flows {
+		c.learn(flow)
+	}
+	return nil
+}
+
+// lookup resolves the original (pre-DNAT) destination for a captured event,
+// falling back to a direct conntrack table query on cache miss.
+func (c *conntrackEnricher) lookup(event AudioEvent) (origDst, bool) {
+	key := keyFromEvent(event)
+
+	if dst, ok := c.get(key); ok {
+		return dst, true
+	}
+
+	flows, err := netlink.ConntrackTableList(netlink.ConntrackTable, unix.AF_INET)
+	if err != nil {
+		log.Printf("conntrack fallback lookup failed: %v", err)
+		return origDst{}, false
+	}
+	for _, flow := range flows {
+		if replyKey(flow) == key {
+			c.learn(flow)
+			return origDst{ip: flow.Forward.DstIP.String(), port: flow.Forward.DstPort}, true
+		}
+	}
+	return origDst{}, false
+}
+
+func (c *conntrackEnricher) learn(flow *netlink.ConntrackFlow) {
+	c.put(replyKey(flow), origDst{
+		ip:   flow.Forward.DstIP.String(),
+		port: flow.Forward.DstPort,
+	})
+}
+
+// get returns the cached destination for key, promoting it to
+// most-recently-used on a hit.
+func (c *conntrackEnricher) get(key conntrackKey) (origDst, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.cache[key]
+	if !ok {
+		return origDst{}, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*lruEntry).dst, true
+}
+
+// put inserts or updates key, promoting it to most-recently-used and
+// evicting the least-recently-used entry if the cache is full.
+func (c *conntrackEnricher) put(key conntrackKey, dst origDst) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.cache[key]; ok {
+		el.Value.(*lruEntry).dst = dst
+		c.order.MoveToFront(el)
+		return
+	}
+
+	if c.order.Len() >= conntrackMaxEntries {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.cache, oldest.Value.(*lruEntry).key)
+		}
+	}
+
+	c.cache[key] = c.order.PushFront(&lruEntry{key: key, dst: dst})
+}
+
+func (c *conntrackEnricher) remove(key conntrackKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.cache[key]; ok {
+		c.order.Remove(el)
+		delete(c.cache, key)
+	}
+}
+
+// replyKey builds the cache key from a flow's reply tuple: the
+// (podIP:podPort -> clientIP:clientPort) direction, which is what the
+// tracer actually observes on the wire after DNAT.
+func replyKey(flow *netlink.ConntrackFlow) conntrackKey {
+	return conntrackKey{
+		proto:   flow.Reverse.Protocol,
+		srcIP:   flow.Reverse.SrcIP.String(),
+		srcPort: flow.Reverse.SrcPort,
+		dstIP:   flow.Reverse.DstIP.String(),
+		dstPort: flow.Reverse.DstPort,
+	}
+}
+
+// keyFromEvent builds the lookup key for a captured AudioEvent: the wire
+// tuple the tracer actually saw.
+func keyFromEvent(event AudioEvent) conntrackKey {
+	return conntrackKey{
+		proto:   unix.IPPROTO_UDP,
+		srcIP:   intToIP(event.SrcIP),
+		srcPort: event.SrcPort,
+		dstIP:   intToIP(event.DstIP),
+		dstPort: event.DstPort,
+	}
+}
+
+// The nfnetlink conntrack wire format below mirrors
+// include/uapi/linux/netfilter/nfnetlink_conntrack.h and
+// include/uapi/linux/netfilter/nfnetlink.h; only the handful of attributes
+// this enricher needs are decoded.
+const (
+	nfnlSubsysCTNetlink = 1
+	ipctnlMsgCTNew      = 0
+	ipctnlMsgCTDelete   = 2
+
+	nfnlgrpConntrackNew     = 1
+	nfnlgrpConntrackDestroy = 3
+
+	ctaTupleOrig  = 1
+	ctaTupleReply = 2
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+
+	ctaProtoNum     = 1
+	ctaProtoSrcPort = 2
+	ctaProtoDstPort = 3
+
+	nlaTypeMask = 0x3fff // clears NLA_F_NESTED/NLA_F_NET_BYTEORDER
+)
+
+// watch opens a raw NETLINK_NETFILTER socket joined to the conntrack
+// new/destroy multicast groups and keeps the cache in sync with observed
+// flows until done is closed. Meant to run in its own goroutine.
+func (c *conntrackEnricher) watch(done <-chan struct{}) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return fmt.Errorf("failed to open netfilter netlink socket: %w", err)
+	}
+
+	addr := &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: uint32(1)<<(nfnlgrpConntrackNew-1) | uint32(1)<<(nfnlgrpConntrackDestroy-1),
+	}
+	if err := unix.Bind(fd, addr); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("failed to bind netfilter netlink socket: %w", err)
+	}
+
+	go func() {
+		<-done
+		unix.Close(fd)
+	}()
+
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			select {
+			case <-done:
+				return nil
+			default:
+				return fmt.Errorf("conntrack event read: %w", err)
+			}
+		}
+		c.handleRaw(buf[:n])
+	}
+}
+
+func (c *conntrackEnricher) handleRaw(buf []byte) {
+	msgs, err := syscall.ParseNetlinkMessage(buf)
+	if err != nil {
+		log.Printf("failed to parse conntrack netlink message: %v", err)
+		return
+	}
+
+	for _, msg := range msgs {
+		subsys := uint8(msg.Header.Type >> 8)
+		kind := uint8(msg.Header.Type & 0xff)
+		if subsys != nfnlSubsysCTNetlink || len(msg.Data) < 4 {
+			continue
+		}
+
+		// Skip the 4-byte nfgenmsg header (family, version, res_id).
+		orig, gotOrig := conntrackKey{}, false
+		reply, gotReply := conntrackKey{}, false
+		for _, a := range parseAttrs(msg.Data[4:]) {
+			switch a.typ {
+			case ctaTupleOrig:
+				orig, gotOrig = parseTuple(a.data)
+			case ctaTupleReply:
+				reply, gotReply = parseTuple(a.data)
+			}
+		}
+		if !gotOrig || !gotReply {
+			continue
+		}
+
+		switch kind {
+		case ipctnlMsgCTNew:
+			c.put(reply, origDst{ip: orig.dstIP, port: orig.dstPort})
+		case ipctnlMsgCTDelete:
+			c.remove(reply)
+		}
+	}
+}
+
+type nlAttr struct {
+	typ  uint16
+	data []byte
+}
+
+// parseAttrs walks a buffer of back-to-back, 4-byte-aligned netlink
+// attributes (nlattr: 2-byte length, 2-byte type, then payload).
+func parseAttrs(b []byte) []nlAttr {
+	var attrs []nlAttr
+	for len(b) >= 4 {
+		length := int(binary.LittleEndian.Uint16(b[0:2]))
+		typ := binary.LittleEndian.Uint16(b[2:4])
+		if length < 4 || length > len(b) {
+			break
+		}
+		attrs = append(attrs, nlAttr{typ: typ & nlaTypeMask, data: b[4:length]})
+
+		aligned := (length + 3) &^ 3
+		if aligned > len(b) {
+			break
+		}
+		b = b[aligned:]
+	}
+	return attrs
+}
+
+// parseTuple decodes a CTA_TUPLE_ORIG/CTA_TUPLE_REPLY nested attribute into
+// a conntrackKey, covering only IPv4/the protocol fields this tracer needs.
+func parseTuple(b []byte) (conntrackKey, bool) {
+	var key conntrackKey
+	var gotIP, gotProto bool
+
+	for _, a := range parseAttrs(b) {
+		switch a.typ {
+		case ctaTupleIP:
+			for _, ipAttr := range parseAttrs(a.data) {
+				switch ipAttr.typ {
+				case ctaIPv4Src:
+					if len(ipAttr.data) == 4 {
+						key.srcIP = net.IP(ipAttr.data).String()
+						gotIP = true
+					}
+				case ctaIPv4Dst:
+					if len(ipAttr.data) == 4 {
+						key.dstIP = net.IP(ipAttr.data).String()
+						gotIP = true
+					}
+				}
+			}
+		case ctaTupleProto:
+			for _, protoAttr := range parseAttrs(a.data) {
+				switch protoAttr.typ {
+				case ctaProtoNum:
+					if len(protoAttr.data) == 1 {
+						key.proto = protoAttr.data[0]
+						gotProto = true
+					}
+				case ctaProtoSrcPort:
+					if len(protoAttr.data) == 2 {
+						key.srcPort = binary.BigEndian.Uint16(protoAttr.data)
+					}
+				case ctaProtoDstPort:
+					if len(protoAttr.data) == 2 {
+						key.dstPort = binary.BigEndian.Uint16(protoAttr.data)
+					}
+				}
+			}
+		}
+	}
+	return key, gotIP && gotProto
+}
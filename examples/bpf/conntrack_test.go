@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// buildAttr serializes a single nlattr (2-byte length, 2-byte type, payload,
+// padded to 4-byte alignment), matching what parseAttrs expects to walk.
+func buildAttr(typ uint16, data []byte) []byte {
+	length := 4 + len(data)
+	buf := make([]byte, length)
+	binary.LittleEndian.PutUint16(buf[0:2], uint16(length))
+	binary.LittleEndian.PutUint16(buf[2:4], typ)
+	copy(buf[4:], data)
+
+	if pad := (4 - length%4) % 4; pad > 0 {
+		buf = append(buf, make([]byte, pad)...)
+	}
+	return buf
+}
+
+func buildAttrs(attrs ...[]byte) []byte {
+	var buf []byte
+	for _, a := range attrs {
+		buf = append(buf, a...)
+	}
+	return buf
+}
+
+func TestParseAttrs(t *testing.T) {
+	buf := buildAttrs(
+		buildAttr(ctaProtoNum, []byte{17}),
+		buildAttr(ctaProtoSrcPort, []byte{0x1f, 0x90}), // 8080 big-endian
+	)
+
+	attrs := parseAttrs(buf)
+	if len(attrs) != 2 {
+		t.Fatalf("got %d attrs, want 2", len(attrs))
+	}
+	if attrs[0].typ != ctaProtoNum || len(attrs[0].data) != 1 || attrs[0].data[0] != 17 {
+		t.Errorf("attr[0] = %+v, want ctaProtoNum=17", attrs[0])
+	}
+	if attrs[1].typ != ctaProtoSrcPort || binary.BigEndian.Uint16(attrs[1].data) != 8080 {
+		t.Errorf("attr[1] = %+v, want ctaProtoSrcPort=8080", attrs[1])
+	}
+}
+
+func TestParseAttrsTruncated(t *testing.T) {
+	// A length field larger than the remaining buffer must stop the walk
+	// rather than panic on an out-of-range slice.
+	buf := []byte{0xff, 0xff, 0x00, 0x00}
+	if attrs := parseAttrs(buf); len(attrs) != 0 {
+		t.Errorf("got %d attrs from truncated buffer, want 0", len(attrs))
+	}
+}
+
+func TestParseTuple(t *testing.T) {
+	ipAttrs := buildAttrs(
+		buildAttr(ctaIPv4Src, []byte{10, 0, 0, 1}),
+		buildAttr(ctaIPv4Dst, []byte{10, 0, 0, 2}),
+	)
+	protoAttrs := buildAttrs(
+		buildAttr(ctaProtoNum, []byte{17}),
+		buildAttr(ctaProtoSrcPort, []byte{0x1f, 0x90}), // 8080
+		buildAttr(ctaProtoDstPort, []byte{0x00, 0x35}), // 53
+	)
+	tuple := buildAttrs(
+		buildAttr(ctaTupleIP, ipAttrs),
+		buildAttr(ctaTupleProto, protoAttrs),
+	)
+
+	key, ok := parseTuple(tuple)
+	if !ok {
+		t.Fatalf("parseTuple returned ok=false")
+	}
+
+	want := conntrackKey{
+		proto:   17,
+		srcIP:   "10.0.0.1",
+		srcPort: 8080,
+		dstIP:   "10.0.0.2",
+		dstPort: 53,
+	}
+	if key != want {
+		t.Errorf("parseTuple() = %+v, want %+v", key, want)
+	}
+}
+
+func TestParseTupleMissingIP(t *testing.T) {
+	protoAttrs := buildAttr(ctaProtoNum, []byte{17})
+	tuple := buildAttr(ctaTupleProto, protoAttrs)
+
+	if _, ok := parseTuple(tuple); ok {
+		t.Errorf("parseTuple() ok=true with no IP attribute, want false")
+	}
+}
+
+func TestConntrackLRUPromotesOnGet(t *testing.T) {
+	c := newConntrackEnricher()
+
+	k1 := conntrackKey{srcIP: "1.1.1.1"}
+	k2 := conntrackKey{srcIP: "2.2.2.2"}
+	c.put(k1, origDst{ip: "1.1.1.1"})
+	c.put(k2, origDst{ip: "2.2.2.2"})
+
+	// k1 is least-recently-used until it's read.
+	if c.order.Back().Value.(*lruEntry).key != k1 {
+		t.Fatalf("expected k1 at the back before get()")
+	}
+	if _, ok := c.get(k1); !ok {
+		t.Fatalf("get(k1) ok=false")
+	}
+	if c.order.Back().Value.(*lruEntry).key != k2 {
+		t.Errorf("expected k2 at the back after get(k1), got %+v", c.order.Back().Value.(*lruEntry).key)
+	}
+}
+
+func TestConntrackLRUPromotesOnPutExisting(t *testing.T) {
+	c := newConntrackEnricher()
+
+	k1 := conntrackKey{srcIP: "1.1.1.1"}
+	k2 := conntrackKey{srcIP: "2.2.2.2"}
+	c.put(k1, origDst{ip: "1.1.1.1"})
+	c.put(k2, origDst{ip: "2.2.2.2"})
+
+	// Re-writing k1 (an existing key) must move it to the front, not just
+	// update its value in place — this is the FIFO-vs-LRU distinction.
+	c.put(k1, origDst{ip: "1.1.1.1", port: 1})
+	if c.order.Front().Value.(*lruEntry).key != k1 {
+		t.Errorf("expected k1 at the front after put(k1) again")
+	}
+	dst, ok := c.get(k1)
+	if !ok || dst.port != 1 {
+		t.Errorf("get(k1) = %+v, %v, want updated port=1", dst, ok)
+	}
+}
+
+func TestConntrackLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newConntrackEnricher()
+
+	for i := 0; i < conntrackMaxEntries; i++ {
+		c.put(conntrackKey{srcIP: fmt.Sprintf("10.0.0.%d", i%256), srcPort: uint16(i)}, origDst{})
+	}
+	first := conntrackKey{srcIP: "10.0.0.0", srcPort: 0}
+
+	// Touch `first` so it's most-recently-used, then push one more entry in:
+	// the cache is full, so something must be evicted, and it must not be
+	// `first`.
+	if _, ok := c.get(first); !ok {
+		t.Fatalf("get(first) ok=false before eviction")
+	}
+	c.put(conntrackKey{srcIP: "255.255.255.255", srcPort: 1}, origDst{})
+
+	if _, ok := c.get(first); !ok {
+		t.Errorf("first entry was evicted despite being most recently used")
+	}
+	if c.order.Len() != conntrackMaxEntries {
+		t.Errorf("cache size = %d, want %d", c.order.Len(), conntrackMaxEntries)
+	}
+}
@@ -0,0 +1,57 @@
+// csv_sink.go - CSV event sink (the original output format)
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+)
+
+type csvSink struct {
+	file   *os.File
+	writer *csv.Writer
+	pods   *podRegistry
+}
+
+func newCSVSink(path string, pods *podRegistry) (Sink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CSV output file: %w", err)
+	}
+
+	w := csv.NewWriter(f)
+	w.Write([]string{
+		"timestamp_ns", "src_ip", "src_port", "dst_ip", "dst_port",
+		"interval_id", "position", "direction", "pod_namespace", "pod_name",
+		"orig_dst_ip", "orig_dst_port",
+	})
+
+	return &csvSink{file: f, writer: w, pods: pods}, nil
+}
+
+func (s *csvSink) Write(event EnrichedEvent) error {
+	podNamespace, podName := lookupPod(s.pods, event.AudioEvent)
+
+	if err := s.writer.Write([]string{
+		fmt.Sprintf("%d", event.TimestampNs),
+		intToIP(event.SrcIP), fmt.Sprintf("%d", event.SrcPort),
+		intToIP(event.DstIP), fmt.Sprintf("%d", event.DstPort),
+		decodeIntervalID(event.AudioEvent), fmt.Sprintf("%d", event.Position),
+		eventDirection(event.AudioEvent), podNamespace, podName,
+		event.OrigDstIP, fmt.Sprintf("%d", event.OrigDstPort),
+	}); err != nil {
+		return err
+	}
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+func (s *csvSink) Flush() error {
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+func (s *csvSink) Close() error {
+	s.writer.Flush()
+	return s.file.Close()
+}
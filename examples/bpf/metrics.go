@@ -0,0 +1,59 @@
+// metrics.go - Prometheus metrics endpoint
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	eventsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "fsm_px_events_total",
+		Help: "Total number of ringbuf events decoded.",
+	})
+	eventsDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "fsm_px_events_dropped_total",
+		Help: "Total number of events dropped because bpf_ringbuf_reserve failed (ringbuf full).",
+	})
+	ringbufFullSeconds = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "fsm_px_ringbuf_full_seconds",
+		Help: "Cumulative seconds during which the ringbuf was observed full and dropping events.",
+	})
+	interfaceAttached = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fsm_px_interface_attached",
+		Help: "Whether the tracer is attached to an interface (1) or not (0), by direction.",
+	}, []string{"interface", "direction"})
+)
+
+func init() {
+	prometheus.MustRegister(eventsTotal, eventsDroppedTotal, ringbufFullSeconds, interfaceAttached)
+}
+
+// recordAttachStatus updates the per-interface attach gauge. attached is
+// false both when a filter was never added and when it's torn down on
+// interface removal.
+func recordAttachStatus(ifaceName string, ingress, attached bool) {
+	direction := "egress"
+	if ingress {
+		direction = "ingress"
+	}
+	value := 0.0
+	if attached {
+		value = 1.0
+	}
+	interfaceAttached.WithLabelValues(ifaceName, direction).Set(value)
+}
+
+// startMetricsServer serves /metrics on addr until the process exits.
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("metrics server error: %v", err)
+		}
+	}()
+}
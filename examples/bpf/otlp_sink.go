@@ -0,0 +1,98 @@
+// otlp_sink.go - OTLP trace sink: each captured event becomes a span
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// otlpSink emits one span per captured event. Events sharing an IntervalID
+// are grouped into the same OTel trace by hashing the interval ID into a
+// trace ID, so a trace view shows the full lifecycle of one audio interval.
+type otlpSink struct {
+	pods     *podRegistry
+	ctx      context.Context
+	exporter *otlptrace.Exporter
+	provider *sdktrace.TracerProvider
+	tracer   oteltrace.Tracer
+}
+
+func newOTLPSink(pods *podRegistry) (Sink, error) {
+	ctx := context.Background()
+
+	exporter, err := otlptrace.New(ctx, otlptracegrpc.NewClient())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+
+	return &otlpSink{
+		pods:     pods,
+		ctx:      ctx,
+		exporter: exporter,
+		provider: provider,
+		tracer:   provider.Tracer("tc-audio-tracer"),
+	}, nil
+}
+
+// intervalTraceID hashes an interval ID into a 16-byte OTel trace ID so
+// every event captured for the same audio interval groups into one trace.
+func intervalTraceID(intervalID string) oteltrace.TraceID {
+	sum := sha256.Sum256([]byte(intervalID))
+	var traceID oteltrace.TraceID
+	copy(traceID[:], sum[:16])
+	return traceID
+}
+
+func (s *otlpSink) Write(event EnrichedEvent) error {
+	intervalID := decodeIntervalID(event.AudioEvent)
+	podNamespace, podName := lookupPod(s.pods, event.AudioEvent)
+	ts := time.Unix(0, int64(event.TimestampNs))
+
+	parent := oteltrace.ContextWithSpanContext(s.ctx, oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+		TraceID:    intervalTraceID(intervalID),
+		SpanID:     oteltrace.SpanID{0x01},
+		TraceFlags: oteltrace.FlagsSampled,
+		Remote:     true,
+	}))
+
+	attrs := []attribute.KeyValue{
+		attribute.String("net.peer.ip", intToIP(event.DstIP)),
+		attribute.Int64("net.peer.port", int64(event.DstPort)),
+		attribute.String("audio.interval_id", intervalID),
+		attribute.Int64("audio.position", int64(event.Position)),
+		attribute.String("audio.direction", eventDirection(event.AudioEvent)),
+		attribute.String("k8s.pod.namespace", podNamespace),
+		attribute.String("k8s.pod.name", podName),
+	}
+	if event.OrigDstIP != "" {
+		attrs = append(attrs,
+			attribute.String("audio.orig_dst_ip", event.OrigDstIP),
+			attribute.Int64("audio.orig_dst_port", int64(event.OrigDstPort)),
+		)
+	}
+
+	_, span := s.tracer.Start(parent, "audio.packet",
+		oteltrace.WithTimestamp(ts),
+		oteltrace.WithAttributes(attrs...),
+	)
+	span.End(oteltrace.WithTimestamp(ts))
+	return nil
+}
+
+func (s *otlpSink) Flush() error {
+	return s.provider.ForceFlush(s.ctx)
+}
+
+func (s *otlpSink) Close() error {
+	return s.provider.Shutdown(s.ctx)
+}
@@ -0,0 +1,137 @@
+// parquet_sink.go - Parquet event sink, batched and rotated by size/time
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetRow is the on-disk schema for audio trace events. Audio traces are
+// highly repetitive and compress and column-prune extremely well, which is
+// the whole point of offering this sink alongside CSV.
+type parquetRow struct {
+	TimestampNs  int64  `parquet:"name=timestamp_ns, type=INT64"`
+	SrcIP        string `parquet:"name=src_ip, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SrcPort      int32  `parquet:"name=src_port, type=INT32"`
+	DstIP        string `parquet:"name=dst_ip, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DstPort      int32  `parquet:"name=dst_port, type=INT32"`
+	IntervalID   string `parquet:"name=interval_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Position     int32  `parquet:"name=position, type=INT32"`
+	Direction    string `parquet:"name=direction, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PodNamespace string `parquet:"name=pod_namespace, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PodName      string `parquet:"name=pod_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OrigDstIP    string `parquet:"name=orig_dst_ip, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OrigDstPort  int32  `parquet:"name=orig_dst_port, type=INT32"`
+}
+
+const (
+	parquetRotateMaxBytes = 128 * 1024 * 1024
+	parquetRotateMaxAge   = 10 * time.Minute
+)
+
+// parquetSink batches rows into rotating Parquet files under dir, rolling
+// over to a new file once the current one gets too large or too old.
+type parquetSink struct {
+	mu      sync.Mutex
+	dir     string
+	pods    *podRegistry
+	fw      source.ParquetFile
+	pw      *writer.ParquetWriter
+	opened  time.Time
+	seq     int
+	written int64
+}
+
+func newParquetSink(dir string, pods *podRegistry) (Sink, error) {
+	s := &parquetSink{dir: dir, pods: pods}
+	if err := s.rotate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *parquetSink) rotate() error {
+	if s.pw != nil {
+		if err := s.pw.WriteStop(); err != nil {
+			return fmt.Errorf("failed to close parquet writer: %w", err)
+		}
+		if err := s.fw.Close(); err != nil {
+			return fmt.Errorf("failed to close parquet file: %w", err)
+		}
+	}
+
+	s.seq++
+	path := filepath.Join(s.dir, fmt.Sprintf("tc_trace-%04d.parquet", s.seq))
+
+	fw, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		return fmt.Errorf("failed to create parquet file %s: %w", path, err)
+	}
+
+	pw, err := writer.NewParquetWriter(fw, new(parquetRow), 4)
+	if err != nil {
+		return fmt.Errorf("failed to create parquet writer: %w", err)
+	}
+
+	s.fw = fw
+	s.pw = pw
+	s.opened = time.Now()
+	s.written = 0
+	return nil
+}
+
+func (s *parquetSink) Write(event EnrichedEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.written >= parquetRotateMaxBytes || time.Since(s.opened) >= parquetRotateMaxAge {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	podNamespace, podName := lookupPod(s.pods, event.AudioEvent)
+	row := parquetRow{
+		TimestampNs:  int64(event.TimestampNs),
+		SrcIP:        intToIP(event.SrcIP),
+		SrcPort:      int32(event.SrcPort),
+		DstIP:        intToIP(event.DstIP),
+		DstPort:      int32(event.DstPort),
+		IntervalID:   decodeIntervalID(event.AudioEvent),
+		Position:     int32(event.Position),
+		Direction:    eventDirection(event.AudioEvent),
+		PodNamespace: podNamespace,
+		PodName:      podName,
+		OrigDstIP:    event.OrigDstIP,
+		OrigDstPort:  int32(event.OrigDstPort),
+	}
+
+	if err := s.pw.Write(row); err != nil {
+		return fmt.Errorf("failed to write parquet row: %w", err)
+	}
+	// Rough accounting: exact row size depends on encoding, but this is
+	// enough to trigger rotation in the right ballpark.
+	s.written += 64
+	return nil
+}
+
+func (s *parquetSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pw.Flush(true)
+}
+
+func (s *parquetSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.pw.WriteStop(); err != nil {
+		return fmt.Errorf("failed to close parquet writer: %w", err)
+	}
+	return s.fw.Close()
+}
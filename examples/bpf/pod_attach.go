@@ -0,0 +1,297 @@
+// pod_attach.go - CRI-driven per-pod network namespace attach mode
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"github.com/cilium/ebpf"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// attachMode selects which side of a veth pair the tracer attaches to.
+type attachMode string
+
+const (
+	attachHostVeth attachMode = "host-veth"
+	attachPodNetns attachMode = "pod-netns"
+	attachBoth     attachMode = "both"
+)
+
+func parseAttachMode(s string) (attachMode, error) {
+	switch attachMode(s) {
+	case attachHostVeth, attachPodNetns, attachBoth:
+		return attachMode(s), nil
+	default:
+		return "", fmt.Errorf("invalid -attach-mode %q (want host-veth, pod-netns, or both)", s)
+	}
+}
+
+// podSandbox describes a running pod network namespace as reported by the
+// CRI runtime, enough to attach the tracer inside it and to attribute
+// captured events back to a pod instead of a bare interface name.
+type podSandbox struct {
+	ID        string
+	Name      string
+	Namespace string
+	UID       string
+	NetnsPath string
+}
+
+// podNetnsAttachment holds everything needed to tear down the tc filters
+// attached inside one pod's network namespace: the filters must be deleted
+// through the same *netlink.Handle they were added with (the package-level
+// netlink.FilterDel operates on the current, i.e. host, namespace and would
+// silently miss them), and the handle and netns itself are kept open until
+// then rather than closed at the end of attachPodSandbox.
+type podNetnsAttachment struct {
+	ns      netns.NsHandle
+	handle  *netlink.Handle
+	filters []*netlink.BpfFilter
+}
+
+func (a *podNetnsAttachment) Close() {
+	for _, f := range a.filters {
+		if err := a.handle.FilterDel(f); err != nil {
+			log.Printf("Failed to delete pod-netns filter: %v", err)
+		}
+	}
+	a.handle.Delete()
+	if err := a.ns.Close(); err != nil {
+		log.Printf("Failed to close pod netns handle: %v", err)
+	}
+}
+
+// podAttachments tracks the pod-netns attachments made across all sandboxes,
+// mirroring how vethWatcher tracks host-veth attachments, so they can be
+// torn down on shutdown instead of leaking for the life of the pod's netns.
+type podAttachments struct {
+	mu    sync.Mutex
+	attms []*podNetnsAttachment
+}
+
+func newPodAttachments() *podAttachments {
+	return &podAttachments{}
+}
+
+func (a *podAttachments) add(attm *podNetnsAttachment) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.attms = append(a.attms, attm)
+}
+
+// closeAll tears down every tracked pod-netns attachment, used on shutdown.
+func (a *podAttachments) closeAll() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, attm := range a.attms {
+		attm.Close()
+	}
+	a.attms = nil
+}
+
+// podRegistry maps a pod netns inode number to the sandbox it belongs to,
+// so events captured with a NetNSInode (populated on the C side from
+// skb->dev->nd_net.net->ns.inum) can be resolved back to a pod name/UID.
+type podRegistry struct {
+	mu    sync.RWMutex
+	byIno map[uint64]podSandbox
+}
+
+func newPodRegistry() *podRegistry {
+	return &podRegistry{byIno: make(map[uint64]podSandbox)}
+}
+
+func (r *podRegistry) add(inode uint64, sb podSandbox) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byIno[inode] = sb
+}
+
+func (r *podRegistry) lookup(inode uint64) (podSandbox, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sb, ok := r.byIno[inode]
+	return sb, ok
+}
+
+// discoverPodSandboxes shells out to crictl against runtimeEndpoint to
+// enumerate running pod sandboxes and their network namespace paths. crictl
+// is the common denominator across containerd and CRI-O installs, so this
+// avoids vendoring a full CRI gRPC client for what is otherwise a couple of
+// JSON lookups.
+func discoverPodSandboxes(runtimeEndpoint string) ([]podSandbox, error) {
+	out, err := exec.Command("crictl", "--runtime-endpoint", runtimeEndpoint, "pods", "-o", "json").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list CRI pod sandboxes: %w", err)
+	}
+
+	var list struct {
+		Items []struct {
+			ID       string `json:"id"`
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+				UID       string `json:"uid"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse crictl pods output: %w", err)
+	}
+
+	var sandboxes []podSandbox
+	for _, item := range list.Items {
+		nsPath, err := inspectPodNetnsPath(runtimeEndpoint, item.ID)
+		if err != nil {
+			log.Printf("Failed to inspect pod sandbox %s: %v", item.ID, err)
+			continue
+		}
+		sandboxes = append(sandboxes, podSandbox{
+			ID:        item.ID,
+			Name:      item.Metadata.Name,
+			Namespace: item.Metadata.Namespace,
+			UID:       item.Metadata.UID,
+			NetnsPath: nsPath,
+		})
+	}
+	return sandboxes, nil
+}
+
+// inspectPodNetnsPath runs `crictl inspectp` for a single sandbox and digs
+// the network namespace path out of its runtime spec. The exact shape of
+// the JSON varies by runtime, so this walks the decoded document generically
+// looking for a linux namespace entry of type "network" rather than binding
+// to one schema.
+func inspectPodNetnsPath(runtimeEndpoint, sandboxID string) (string, error) {
+	out, err := exec.Command("crictl", "--runtime-endpoint", runtimeEndpoint, "inspectp", sandboxID).Output()
+	if err != nil {
+		return "", fmt.Errorf("crictl inspectp %s: %w", sandboxID, err)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		return "", fmt.Errorf("failed to parse crictl inspectp output: %w", err)
+	}
+
+	path, ok := findNetworkNamespacePath(doc)
+	if !ok {
+		return "", fmt.Errorf("no network namespace path found for sandbox %s", sandboxID)
+	}
+	return path, nil
+}
+
+// findNetworkNamespacePath recursively searches a decoded JSON document for
+// an object shaped like {"type": "network", "path": "..."}.
+func findNetworkNamespacePath(node interface{}) (string, bool) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if t, ok := v["type"].(string); ok && t == "network" {
+			if path, ok := v["path"].(string); ok && path != "" {
+				return path, true
+			}
+		}
+		for _, child := range v {
+			if path, ok := findNetworkNamespacePath(child); ok {
+				return path, true
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if path, ok := findNetworkNamespacePath(child); ok {
+				return path, true
+			}
+		}
+	}
+	return "", false
+}
+
+// netnsInode stats a netns path for the inode number the kernel uses to
+// identify it, i.e. the same ns.inum value the C side stores in NetNSInode.
+func netnsInode(path string) (uint64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("unsupported platform for netns inode lookup")
+	}
+	return st.Ino, nil
+}
+
+// attachPodSandbox enters sb's network namespace and attaches prog to its
+// eth0 interface (ingress and egress), registering the sandbox under its
+// netns inode so captured events can be attributed back to the pod. The
+// netns handle and both filters are tracked in attms so they can be torn
+// down on shutdown; unlike a one-shot attach, the handle and netns are kept
+// open past this function's return for that teardown to be possible.
+func attachPodSandbox(prog *ebpf.Program, sb podSandbox, registry *podRegistry, attms *podAttachments) error {
+	inode, err := netnsInode(sb.NetnsPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat netns %s: %w", sb.NetnsPath, err)
+	}
+
+	ns, err := netns.GetFromPath(sb.NetnsPath)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %s: %w", sb.NetnsPath, err)
+	}
+
+	handle, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		ns.Close()
+		return fmt.Errorf("failed to get netlink handle for netns %s: %w", sb.NetnsPath, err)
+	}
+
+	ingress, err := attachTCInHandle(handle, prog, "eth0", true)
+	if err != nil {
+		handle.Delete()
+		ns.Close()
+		return fmt.Errorf("failed to attach pod %s/%s ingress: %w", sb.Namespace, sb.Name, err)
+	}
+
+	egress, err := attachTCInHandle(handle, prog, "eth0", false)
+	if err != nil {
+		handle.FilterDel(ingress)
+		handle.Delete()
+		ns.Close()
+		return fmt.Errorf("failed to attach pod %s/%s egress: %w", sb.Namespace, sb.Name, err)
+	}
+
+	attms.add(&podNetnsAttachment{ns: ns, handle: handle, filters: []*netlink.BpfFilter{ingress, egress}})
+	registry.add(inode, sb)
+	log.Printf("Attached to pod %s/%s (netns %s)", sb.Namespace, sb.Name, sb.NetnsPath)
+	return nil
+}
+
+// attachPodSandboxes attaches to every discovered sandbox, logging but not
+// failing on individual sandboxes that can't be attached (e.g. host-network
+// pods with no dedicated eth0). The returned podAttachments must be closed
+// by the caller on shutdown to tear down the pod-netns filters.
+func attachPodSandboxes(prog *ebpf.Program, runtimeEndpoint string, registry *podRegistry) (*podAttachments, error) {
+	sandboxes, err := discoverPodSandboxes(runtimeEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	attms := newPodAttachments()
+	attached := 0
+	for _, sb := range sandboxes {
+		if err := attachPodSandbox(prog, sb, registry, attms); err != nil {
+			log.Printf("Skipping pod sandbox %s: %v", sb.ID, err)
+			continue
+		}
+		attached++
+	}
+
+	if attached == 0 {
+		return attms, fmt.Errorf("failed to attach to any pod sandbox")
+	}
+	return attms, nil
+}
@@ -0,0 +1,142 @@
+// ringbuf_consumer.go - bounded producer/consumer pipeline with drop accounting
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// lostEventsPollInterval controls how often the kernel-side lost-events
+// counter is sampled and republished as a metric.
+const lostEventsPollInterval = 2 * time.Second
+
+// eventConsumer decouples ringbuf reading from sink writing: the reader
+// goroutine only decodes records into a bounded channel, and a pool of
+// workers drains it into the sink. Previously the reader flushed the sink
+// synchronously inline, so a disk stall backed straight up into the ringbuf
+// read loop and the kernel silently dropped events; now the channel absorbs
+// sink stalls up to its capacity. Once the channel is full, the reader blocks
+// on send until a worker frees a slot.
+type eventConsumer struct {
+	rd        *ringbuf.Reader
+	sink      Sink
+	conntrack *conntrackEnricher
+	pods      *podRegistry
+	events    chan AudioEvent
+
+	// totalEvents and eventsWithInterval are incremented from the single
+	// read() goroutine and up to *eventWorkers concurrent work() goroutines
+	// respectively, and read back from run()'s stats ticker, so both need
+	// atomic access rather than plain increments.
+	totalEvents        atomic.Uint64
+	eventsWithInterval atomic.Uint64
+}
+
+func newEventConsumer(rd *ringbuf.Reader, sink Sink, conntrack *conntrackEnricher, pods *podRegistry, chanSize int) *eventConsumer {
+	return &eventConsumer{
+		rd:        rd,
+		sink:      sink,
+		conntrack: conntrack,
+		pods:      pods,
+		events:    make(chan AudioEvent, chanSize),
+	}
+}
+
+// run starts workers workers draining the event channel and then blocks
+// reading the ringbuf until rd is closed.
+func (c *eventConsumer) run(workers int) {
+	for i := 0; i < workers; i++ {
+		go c.work()
+	}
+	c.read()
+}
+
+func (c *eventConsumer) read() {
+	for {
+		record, err := c.rd.Read()
+		if err != nil {
+			if err == ringbuf.ErrClosed {
+				close(c.events)
+				return
+			}
+			log.Printf("Error reading ringbuf: %v", err)
+			continue
+		}
+
+		var event AudioEvent
+		if err := binary.Read(bytes.NewReader(record.RawSample), binary.LittleEndian, &event); err != nil {
+			log.Printf("Error parsing event: %v", err)
+			continue
+		}
+
+		c.totalEvents.Add(1)
+		eventsTotal.Inc()
+		c.events <- event
+	}
+}
+
+func (c *eventConsumer) work() {
+	for event := range c.events {
+		if event.FoundInterval == 0 || decodeIntervalID(event) == "" {
+			continue
+		}
+		c.eventsWithInterval.Add(1)
+
+		enriched := EnrichedEvent{AudioEvent: event}
+		if c.conntrack != nil {
+			if dst, ok := c.conntrack.lookup(event); ok {
+				enriched.OrigDstIP, enriched.OrigDstPort = dst.ip, dst.port
+			}
+		}
+
+		if err := c.sink.Write(enriched); err != nil {
+			log.Printf("Error writing event to sink: %v", err)
+			continue
+		}
+
+		podNamespace, podName := lookupPod(c.pods, event)
+		log.Printf("Captured: %s:%d -> %s:%d interval_id=%s dir=%s pod=%s/%s",
+			intToIP(event.SrcIP), event.SrcPort, intToIP(event.DstIP), event.DstPort,
+			decodeIntervalID(event), eventDirection(event), podNamespace, podName)
+	}
+}
+
+// watchLostEvents polls the kernel-side lost-events counter — a
+// BPF_MAP_TYPE_ARRAY of one u64, incremented on the C side whenever
+// bpf_ringbuf_reserve fails — and republishes the delta as metrics, so
+// ringbuf overflow under load is observable instead of silent. lostMap may
+// be nil if the program doesn't export the counter, in which case this is a
+// no-op.
+func watchLostEvents(lostMap *ebpf.Map, done <-chan struct{}) {
+	if lostMap == nil {
+		return
+	}
+
+	ticker := time.NewTicker(lostEventsPollInterval)
+	defer ticker.Stop()
+
+	var lastLost uint64
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			var lost uint64
+			if err := lostMap.Lookup(uint32(0), &lost); err != nil {
+				log.Printf("Error reading lost-events counter: %v", err)
+				continue
+			}
+			if lost > lastLost {
+				eventsDroppedTotal.Add(float64(lost - lastLost))
+				ringbufFullSeconds.Add(lostEventsPollInterval.Seconds())
+				lastLost = lost
+			}
+		}
+	}
+}
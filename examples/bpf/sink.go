@@ -0,0 +1,103 @@
+// sink.go - pluggable AudioEvent output sinks
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sink is the destination for captured audio trace events. Separating
+// "where events go" from "how they're decoded" lets the tracer gain new
+// output formats without touching the ringbuf consumer.
+type Sink interface {
+	Write(EnrichedEvent) error
+	Flush() error
+	Close() error
+}
+
+// EnrichedEvent is an AudioEvent plus the fields the consumer pipeline adds
+// on top of what the kernel program captured, such as the pre-DNAT
+// destination resolved via conntrack.
+type EnrichedEvent struct {
+	AudioEvent
+	OrigDstIP   string
+	OrigDstPort uint16
+}
+
+// newSinks builds one Sink per comma-separated name in sinkNames (e.g.
+// "csv,otlp") and, when more than one is requested, composes them into a
+// teeSink so events are written to all of them.
+func newSinks(sinkNames string, pods *podRegistry) (Sink, error) {
+	var names []string
+	for _, name := range strings.Split(sinkNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no sinks configured")
+	}
+
+	var sinks []Sink
+	for _, name := range names {
+		sink, err := newSink(name, pods)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return &teeSink{sinks: sinks}, nil
+}
+
+func newSink(name string, pods *podRegistry) (Sink, error) {
+	switch name {
+	case "csv":
+		return newCSVSink("/output/tc_trace.csv", pods)
+	case "parquet":
+		return newParquetSink("/output", pods)
+	case "otlp":
+		return newOTLPSink(pods)
+	default:
+		return nil, fmt.Errorf("unknown sink %q (want csv, parquet, or otlp)", name)
+	}
+}
+
+// teeSink fans writes out to multiple sinks, so e.g. -sink=csv,otlp keeps
+// the CSV file analysts already have while also feeding a tracing backend.
+type teeSink struct {
+	sinks []Sink
+}
+
+func (t *teeSink) Write(event EnrichedEvent) error {
+	var firstErr error
+	for _, s := range t.sinks {
+		if err := s.Write(event); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (t *teeSink) Flush() error {
+	var firstErr error
+	for _, s := range t.sinks {
+		if err := s.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (t *teeSink) Close() error {
+	var firstErr error
+	for _, s := range t.sinks {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -3,14 +3,14 @@ package main
 
 import (
 	"bytes"
-	"encoding/binary"
-	"encoding/csv"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/signal"
-	"strings"
+	"regexp"
 	"syscall"
 	"time"
 
@@ -21,20 +21,38 @@ import (
 	"golang.org/x/sys/unix"
 )
 
+var (
+	includeIface = flag.String("iface-include", "", "only trace veth interfaces matching this regex (default: all)")
+	excludeIface = flag.String("iface-exclude", "", "skip veth interfaces matching this regex")
+
+	attachModeFlag     = flag.String("attach-mode", "host-veth", "where to attach the tracer: host-veth, pod-netns, or both")
+	criRuntimeEndpoint = flag.String("cri-runtime-endpoint", "unix:///run/containerd/containerd.sock", "CRI runtime endpoint used to discover pod sandboxes in pod-netns/both attach mode")
+
+	sinkFlag = flag.String("sink", "csv", "comma-separated output sinks: csv, parquet, otlp")
+
+	conntrackEnrich = flag.Bool("conntrack-enrich", false, "resolve the pre-DNAT destination (ClusterIP/Service) for each event via conntrack")
+
+	eventChanSize = flag.Int("event-chan-size", 4096, "size of the bounded channel between the ringbuf reader and sink workers")
+	eventWorkers  = flag.Int("event-workers", 4, "number of worker goroutines draining decoded events into the sink")
+	metricsAddr   = flag.String("metrics-addr", ":9090", "address to serve Prometheus /metrics on")
+)
+
 // Must match the C structure
 type AudioEvent struct {
-	TimestampNs  uint64
-	SrcIP        uint32
-	DstIP        uint32
-	SrcPort      uint16
-	DstPort      uint16
-	IntervalID   [37]byte
-	Position     uint32
+	TimestampNs   uint64
+	SrcIP         uint32
+	DstIP         uint32
+	SrcPort       uint16
+	DstPort       uint16
+	IntervalID    [37]byte
+	Position      uint32
 	FoundInterval uint8
-	_            [3]byte // padding
+	_             [3]byte // padding
+	NetNSInode    uint64  // netns inode of the interface the event was captured on, for pod attribution
 }
 
 func main() {
+	flag.Parse()
 	if err := run(); err != nil {
 		log.Fatalf("Error: %v", err)
 	}
@@ -64,61 +82,88 @@ func run() error {
 		return fmt.Errorf("program tc_audio_trace not found")
 	}
 
-	// Find all veth interfaces and attach TC
-	interfaces, err := findVethInterfaces()
-	if err != nil {
-		return fmt.Errorf("failed to find interfaces: %w", err)
-	}
-
-	log.Printf("Found %d veth interfaces", len(interfaces))
-
-	// Attach to each interface
-	var filters []*netlink.BpfFilter
-	for _, iface := range interfaces {
-		// Attach to ingress
-		f, err := attachTC(prog, iface, true)
+	// Parse the include/exclude interface name patterns, if any, so
+	// operators can restrict tracing to e.g. a CNI's veth* prefix.
+	var includeRe, excludeRe *regexp.Regexp
+	if *includeIface != "" {
+		includeRe, err = regexp.Compile(*includeIface)
 		if err != nil {
-			log.Printf("Failed to attach to %s ingress: %v", iface, err)
-			continue
+			return fmt.Errorf("invalid -iface-include pattern: %w", err)
 		}
-		filters = append(filters, f)
-		
-		// Also attach to egress
-		f, err = attachTC(prog, iface, false)
+	}
+	if *excludeIface != "" {
+		excludeRe, err = regexp.Compile(*excludeIface)
 		if err != nil {
-			log.Printf("Failed to attach to %s egress: %v", iface, err)
-			continue
+			return fmt.Errorf("invalid -iface-exclude pattern: %w", err)
 		}
-		filters = append(filters, f)
-		
-		log.Printf("Attached to interface %s", iface)
 	}
 
-	if len(filters) == 0 {
-		return fmt.Errorf("failed to attach to any interface")
+	mode, err := parseAttachMode(*attachModeFlag)
+	if err != nil {
+		return err
 	}
 
-	defer func() {
-		for _, f := range filters {
-			netlink.FilterDel(f)
+	pods := newPodRegistry()
+
+	// Attach to the veth interfaces present today, then keep watching for
+	// veth pairs created or removed later (new pods, container restarts).
+	var watcher *vethWatcher
+	if mode == attachHostVeth || mode == attachBoth {
+		watcher = newVethWatcher(prog, includeRe, excludeRe)
+		if err := watcher.attachAll(); err != nil {
+			return err
 		}
-	}()
+		defer watcher.closeAll()
 
-	// Open output CSV file
-	outputFile, err := os.Create("/output/tc_trace.csv")
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		watchDone := make(chan struct{})
+		defer close(watchDone)
+		go func() {
+			if err := watcher.run(watchDone); err != nil {
+				log.Printf("Error watching veth links: %v", err)
+			}
+		}()
+	}
+
+	if mode == attachPodNetns || mode == attachBoth {
+		podAttms, err := attachPodSandboxes(prog, *criRuntimeEndpoint, pods)
+		if podAttms != nil {
+			defer podAttms.closeAll()
+		}
+		if err != nil {
+			if mode == attachPodNetns {
+				return err
+			}
+			log.Printf("Pod netns attach: %v", err)
+		}
 	}
-	defer outputFile.Close()
 
-	csvWriter := csv.NewWriter(outputFile)
-	defer csvWriter.Flush()
+	// Build the configured output sink(s). csv is the original default;
+	// parquet and otlp are selected via -sink, and can be combined (tee'd)
+	// with csv, e.g. -sink=csv,otlp.
+	sink, err := newSinks(*sinkFlag, pods)
+	if err != nil {
+		return fmt.Errorf("failed to build sink: %w", err)
+	}
+	defer sink.Close()
+
+	// Optionally resolve the pre-DNAT destination for each event via
+	// conntrack, so events captured post-kube-proxy can still be
+	// attributed to the ClusterIP/Service the client dialed.
+	var conntrack *conntrackEnricher
+	if *conntrackEnrich {
+		conntrack = newConntrackEnricher()
+		if err := conntrack.seed(); err != nil {
+			log.Printf("Failed to seed conntrack cache: %v", err)
+		}
 
-	// Write header
-	csvWriter.Write([]string{
-		"timestamp_ns", "src_ip", "src_port", "dst_ip", "dst_port", 
-		"interval_id", "position", "direction",
-	})
+		conntrackDone := make(chan struct{})
+		defer close(conntrackDone)
+		go func() {
+			if err := conntrack.watch(conntrackDone); err != nil {
+				log.Printf("Error watching conntrack updates: %v", err)
+			}
+		}()
+	}
 
 	// Open ringbuf reader
 	rd, err := ringbuf.NewReader(coll.Maps["events"])
@@ -127,72 +172,27 @@ func run() error {
 	}
 	defer rd.Close()
 
+	startMetricsServer(*metricsAddr)
+
+	lostDone := make(chan struct{})
+	defer close(lostDone)
+	go watchLostEvents(coll.Maps["lost_events"], lostDone)
+
 	// Handle signals
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 
 	log.Println("Listening for events... Press Ctrl+C to stop")
 
-	// Stats
-	var totalEvents, eventsWithInterval uint64
+	// The reader only decodes into a bounded channel; a worker pool drains
+	// it into the sink, so a slow sink first fills the channel; the ringbuf
+	// read loop only blocks once the channel is full.
+	consumer := newEventConsumer(rd, sink, conntrack, pods, *eventChanSize)
+	go consumer.run(*eventWorkers)
+
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
-	// Read events
-	go func() {
-		for {
-			record, err := rd.Read()
-			if err != nil {
-				if err == ringbuf.ErrClosed {
-					return
-				}
-				log.Printf("Error reading ringbuf: %v", err)
-				continue
-			}
-
-			// Parse event
-			var event AudioEvent
-			if err := binary.Read(bytes.NewReader(record.RawSample), binary.LittleEndian, &event); err != nil {
-				log.Printf("Error parsing event: %v", err)
-				continue
-			}
-
-			totalEvents++
-			
-			// Convert IPs
-			srcIP := intToIP(event.SrcIP)
-			dstIP := intToIP(event.DstIP)
-			
-			// Extract interval ID (null-terminated string)
-			intervalID := string(bytes.TrimRight(event.IntervalID[:], "\x00"))
-			
-			// Determine direction
-			direction := "unknown"
-			if event.SrcPort == 8000 {
-				direction = "from_source"
-			} else if event.DstPort == 8001 {
-				direction = "to_relay"
-			}
-			
-			// Only log events with interval_id
-			if event.FoundInterval > 0 && intervalID != "" {
-				eventsWithInterval++
-				csvWriter.Write([]string{
-					fmt.Sprintf("%d", event.TimestampNs),
-					srcIP, fmt.Sprintf("%d", event.SrcPort),
-					dstIP, fmt.Sprintf("%d", event.DstPort),
-					intervalID, fmt.Sprintf("%d", event.Position),
-					direction,
-				})
-				csvWriter.Flush()
-				
-				log.Printf("Captured: %s:%d -> %s:%d interval_id=%s dir=%s",
-					srcIP, event.SrcPort, dstIP, event.DstPort, 
-					intervalID, direction)
-			}
-		}
-	}()
-
 	// Wait for signal or stats
 	for {
 		select {
@@ -200,48 +200,72 @@ func run() error {
 			log.Println("Received signal, exiting...")
 			return nil
 		case <-ticker.C:
+			total := consumer.totalEvents.Load()
+			withInterval := consumer.eventsWithInterval.Load()
 			log.Printf("Stats: %d total events, %d with interval_id (%.1f%%)",
-				totalEvents, eventsWithInterval,
-				float64(eventsWithInterval)/float64(totalEvents+1)*100)
+				total, withInterval, float64(withInterval)/float64(total+1)*100)
 		}
 	}
 }
 
-func findVethInterfaces() ([]string, error) {
+func findVethInterfaces() ([]netlink.Link, error) {
 	links, err := netlink.LinkList()
 	if err != nil {
 		return nil, err
 	}
 
-	var interfaces []string
+	var interfaces []netlink.Link
 	for _, link := range links {
 		// Check if it's a veth interface
 		if link.Type() == "veth" {
-			interfaces = append(interfaces, link.Attrs().Name)
+			interfaces = append(interfaces, link)
 		}
 	}
-	
+
 	return interfaces, nil
 }
 
-func attachTC(prog *ebpf.Program, ifaceName string, ingress bool) (*netlink.BpfFilter, error) {
+// attachTC attaches prog to ifaceName, preferring the TCX hook over the
+// classic clsact-qdisc-plus-filter path where the kernel supports it.
+func attachTC(prog *ebpf.Program, ifaceName string, ingress bool) (*tcAttachment, error) {
 	iface, err := net.InterfaceByName(ifaceName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get interface %s: %w", ifaceName, err)
 	}
 
+	return attachTCAuto(prog, iface.Index, ingress)
+}
+
+// attachTCInHandle attaches prog to ifaceName inside a non-default netlink
+// handle, e.g. one obtained via netlink.NewHandleAt(ns) for a pod network
+// namespace. The returned filter must be deleted through the same handle
+// (not the package-level netlink.FilterDel, which targets the current
+// namespace) once the caller is done with it.
+func attachTCInHandle(handle *netlink.Handle, prog *ebpf.Program, ifaceName string, ingress bool) (*netlink.BpfFilter, error) {
+	link, err := handle.LinkByName(ifaceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interface %s: %w", ifaceName, err)
+	}
+
+	return attachTCIndex(handle.QdiscAdd, handle.FilterAdd, prog, link.Attrs().Index, ingress)
+}
+
+// attachTCIndex holds the qdisc/filter setup shared by the host and
+// namespaced attach paths, parameterized on the add functions so it works
+// against either the package-level default handle or a netlink.Handle.
+func attachTCIndex(qdiscAdd func(netlink.Qdisc) error, filterAdd func(netlink.Filter) error, prog *ebpf.Program, ifindex int, ingress bool) (*netlink.BpfFilter, error) {
 	// Ensure clsact qdisc exists
 	qdisc := &netlink.GenericQdisc{
 		QdiscAttrs: netlink.QdiscAttrs{
-			LinkIndex: iface.Index,
+			LinkIndex: ifindex,
 			Handle:    netlink.MakeHandle(0xffff, 0),
 			Parent:    netlink.HANDLE_CLSACT,
 		},
 		QdiscType: "clsact",
 	}
-	
-	// Try to add, ignore "exists" error
-	if err := netlink.QdiscAdd(qdisc); err != nil && !strings.Contains(err.Error(), "exists") {
+
+	// Try to add, ignore an already-exists error
+	if err := qdiscAdd(qdisc); err != nil && !errors.Is(err, unix.EEXIST) {
 		return nil, fmt.Errorf("failed to add clsact qdisc: %w", err)
 	}
 
@@ -255,7 +279,7 @@ func attachTC(prog *ebpf.Program, ifaceName string, ingress bool) (*netlink.BpfF
 
 	filter := &netlink.BpfFilter{
 		FilterAttrs: netlink.FilterAttrs{
-			LinkIndex: iface.Index,
+			LinkIndex: ifindex,
 			Parent:    parent,
 			Priority:  1,
 			Protocol:  unix.ETH_P_ALL,
@@ -265,7 +289,7 @@ func attachTC(prog *ebpf.Program, ifaceName string, ingress bool) (*netlink.BpfF
 		DirectAction: true,
 	}
 
-	if err := netlink.FilterAdd(filter); err != nil {
+	if err := filterAdd(filter); err != nil {
 		return nil, fmt.Errorf("failed to add filter: %w", err)
 	}
 
@@ -275,4 +299,35 @@ func attachTC(prog *ebpf.Program, ifaceName string, ingress bool) (*netlink.BpfF
 
 func intToIP(ip uint32) string {
 	return fmt.Sprintf("%d.%d.%d.%d", ip&0xff, (ip>>8)&0xff, (ip>>16)&0xff, (ip>>24)&0xff)
+}
+
+// decodeIntervalID extracts the null-terminated interval ID string from the
+// fixed-size C array.
+func decodeIntervalID(event AudioEvent) string {
+	return string(bytes.TrimRight(event.IntervalID[:], "\x00"))
+}
+
+// eventDirection classifies an event by the well-known source/destination
+// ports the audio tracer is watching for.
+func eventDirection(event AudioEvent) string {
+	switch {
+	case event.SrcPort == 8000:
+		return "from_source"
+	case event.DstPort == 8001:
+		return "to_relay"
+	default:
+		return "unknown"
+	}
+}
+
+// lookupPod resolves the pod namespace/name attributed to event via its
+// netns inode, if pods is non-nil and a match is known.
+func lookupPod(pods *podRegistry, event AudioEvent) (namespace, name string) {
+	if pods == nil {
+		return "", ""
+	}
+	if sb, ok := pods.lookup(event.NetNSInode); ok {
+		return sb.Namespace, sb.Name
+	}
+	return "", ""
 }
\ No newline at end of file
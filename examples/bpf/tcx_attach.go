@@ -0,0 +1,113 @@
+// tcx_attach.go - TCX attach path (kernel 6.6+) with fallback to classic tc-bpf
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
+	"github.com/cilium/ebpf/link"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// minTCXKernelVersion is the first kernel release that exposes the TCX
+// attach hook (the bpf_mprog-based tc attach path), so it's preferred over
+// hand-rolling a clsact qdisc and a classic tc-bpf filter.
+var minTCXKernelVersion = [3]int{6, 6, 0}
+
+var (
+	tcxOnce      sync.Once
+	tcxSupported bool
+)
+
+// haveTCX probes once whether this kernel supports TCX: the program type
+// must be usable at all, and the running kernel must be new enough to
+// expose the TCX hook.
+func haveTCX() bool {
+	tcxOnce.Do(func() {
+		if err := features.HaveProgramType(ebpf.SchedCLS); err != nil {
+			tcxSupported = false
+			return
+		}
+		tcxSupported = kernelAtLeast(minTCXKernelVersion)
+	})
+	return tcxSupported
+}
+
+func kernelAtLeast(min [3]int) bool {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return false
+	}
+
+	// Release looks like "6.6.1-generic"; take the dotted version prefix.
+	release := strings.SplitN(unix.ByteSliceToString(uname.Release[:]), "-", 2)[0]
+	parts := strings.SplitN(release, ".", 3)
+
+	var version [3]int
+	for i := 0; i < len(parts) && i < 3; i++ {
+		v, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return false
+		}
+		version[i] = v
+	}
+
+	for i := 0; i < 3; i++ {
+		if version[i] != min[i] {
+			return version[i] > min[i]
+		}
+	}
+	return true
+}
+
+// tcAttachment is a handle to a tc attachment made via either the TCX or
+// the classic tc-bpf path, so callers can tear it down without caring
+// which one was actually used.
+type tcAttachment struct {
+	tcxLink link.Link
+	filter  *netlink.BpfFilter
+}
+
+func (a *tcAttachment) Close() error {
+	if a.tcxLink != nil {
+		return a.tcxLink.Close()
+	}
+	if a.filter != nil {
+		return netlink.FilterDel(a.filter)
+	}
+	return nil
+}
+
+// attachTCAuto attaches prog to ifindex in the current network namespace,
+// preferring the TCX hook (proper refcounting via a link.Link, no qdisc
+// juggling) and falling back to the classic clsact-qdisc-plus-filter path
+// when TCX isn't available on this kernel.
+func attachTCAuto(prog *ebpf.Program, ifindex int, ingress bool) (*tcAttachment, error) {
+	if haveTCX() {
+		attach := ebpf.AttachTCXIngress
+		if !ingress {
+			attach = ebpf.AttachTCXEgress
+		}
+
+		l, err := link.AttachTCX(link.TCXOptions{
+			Program:   prog,
+			Attach:    attach,
+			Interface: ifindex,
+		})
+		if err == nil {
+			return &tcAttachment{tcxLink: l}, nil
+		}
+		// Fall through to classic tc-bpf on any TCX attach failure
+		// (e.g. an older kernel the version check didn't catch).
+	}
+
+	filter, err := attachTCIndex(netlink.QdiscAdd, netlink.FilterAdd, prog, ifindex, ingress)
+	if err != nil {
+		return nil, err
+	}
+	return &tcAttachment{filter: filter}, nil
+}
@@ -0,0 +1,179 @@
+// veth_watcher.go - Dynamic veth discovery via netlink link subscription
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/cilium/ebpf"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// vethWatcher tracks attached TC filters per-interface so the tracer can
+// follow veth pairs coming and going on a churning Kubernetes node instead
+// of only seeing the interfaces present at startup.
+type vethWatcher struct {
+	prog    *ebpf.Program
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+
+	// ingress/egress attachments keyed by ifindex, guarding against
+	// double-attach on duplicate NEWLINK notifications.
+	ingress map[int]*tcAttachment
+	egress  map[int]*tcAttachment
+
+	// ifaceNames remembers the interface name for each tracked ifindex, so
+	// detach (which only gets an ifindex from DELLINK) can still report
+	// per-interface attach status.
+	ifaceNames map[int]string
+}
+
+func newVethWatcher(prog *ebpf.Program, include, exclude *regexp.Regexp) *vethWatcher {
+	return &vethWatcher{
+		prog:       prog,
+		include:    include,
+		exclude:    exclude,
+		ingress:    make(map[int]*tcAttachment),
+		egress:     make(map[int]*tcAttachment),
+		ifaceNames: make(map[int]string),
+	}
+}
+
+// matches reports whether ifaceName should be traced given the configured
+// include/exclude patterns. An empty include pattern matches everything.
+func (w *vethWatcher) matches(ifaceName string) bool {
+	if w.exclude != nil && w.exclude.MatchString(ifaceName) {
+		return false
+	}
+	if w.include != nil && !w.include.MatchString(ifaceName) {
+		return false
+	}
+	return true
+}
+
+// attachAll walks the current veth interfaces and attaches the tracer,
+// seeding the watcher's filter maps before the subscription loop starts.
+func (w *vethWatcher) attachAll() error {
+	interfaces, err := findVethInterfaces()
+	if err != nil {
+		return fmt.Errorf("failed to find interfaces: %w", err)
+	}
+
+	for _, iface := range interfaces {
+		if !w.matches(iface.Attrs().Name) {
+			continue
+		}
+		w.attach(iface)
+	}
+
+	if len(w.ingress) == 0 && len(w.egress) == 0 {
+		return fmt.Errorf("failed to attach to any interface")
+	}
+	return nil
+}
+
+// attach attaches the tracer to link on ingress and egress via attachTC
+// (TCX where supported, classic clsact qdisc plus filter otherwise),
+// skipping any direction already tracked for this ifindex.
+func (w *vethWatcher) attach(link netlink.Link) {
+	ifindex := link.Attrs().Index
+	name := link.Attrs().Name
+	w.ifaceNames[ifindex] = name
+
+	if _, ok := w.ingress[ifindex]; !ok {
+		f, err := attachTC(w.prog, name, true)
+		if err != nil {
+			log.Printf("Failed to attach to %s ingress: %v", name, err)
+		} else {
+			w.ingress[ifindex] = f
+		}
+		recordAttachStatus(name, true, err == nil)
+	}
+
+	if _, ok := w.egress[ifindex]; !ok {
+		f, err := attachTC(w.prog, name, false)
+		if err != nil {
+			log.Printf("Failed to attach to %s egress: %v", name, err)
+		} else {
+			w.egress[ifindex] = f
+		}
+		recordAttachStatus(name, false, err == nil)
+	}
+
+	if w.ingress[ifindex] != nil || w.egress[ifindex] != nil {
+		log.Printf("Attached to interface %s", name)
+	}
+}
+
+// detach removes the tracked filters for ifindex, if any, so cleanup
+// doesn't crash trying to delete filters on an FD that's already gone.
+func (w *vethWatcher) detach(ifindex int) {
+	name := w.ifaceNames[ifindex]
+
+	if a, ok := w.ingress[ifindex]; ok {
+		a.Close()
+		delete(w.ingress, ifindex)
+		recordAttachStatus(name, true, false)
+	}
+	if a, ok := w.egress[ifindex]; ok {
+		a.Close()
+		delete(w.egress, ifindex)
+		recordAttachStatus(name, false, false)
+	}
+	delete(w.ifaceNames, ifindex)
+}
+
+// closeAll removes every tracked filter, used on shutdown.
+func (w *vethWatcher) closeAll() {
+	for ifindex := range w.ingress {
+		w.detach(ifindex)
+	}
+	for ifindex := range w.egress {
+		w.detach(ifindex)
+	}
+}
+
+// run subscribes to RTM_NEWLINK/RTM_DELLINK notifications and keeps the
+// watcher's attachments in sync with veth lifecycle events until done is
+// closed. It is meant to run in its own goroutine.
+func (w *vethWatcher) run(done <-chan struct{}) error {
+	updates := make(chan netlink.LinkUpdate)
+	if err := netlink.LinkSubscribe(updates, done); err != nil {
+		return fmt.Errorf("failed to subscribe to link updates: %w", err)
+	}
+
+	for {
+		select {
+		case <-done:
+			return nil
+		case update, ok := <-updates:
+			if !ok {
+				return nil
+			}
+			w.handleUpdate(update)
+		}
+	}
+}
+
+func (w *vethWatcher) handleUpdate(update netlink.LinkUpdate) {
+	link := update.Link
+	if link.Type() != "veth" {
+		return
+	}
+	name := link.Attrs().Name
+	if !w.matches(name) {
+		return
+	}
+
+	switch update.Header.Type {
+	case unix.RTM_NEWLINK:
+		if link.Attrs().OperState != netlink.OperUp {
+			return
+		}
+		w.attach(link)
+	case unix.RTM_DELLINK:
+		w.detach(link.Attrs().Index)
+	}
+}